@@ -3,6 +3,7 @@ package zfsdriver
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/clinta/go-zfs"
@@ -14,26 +15,78 @@ import (
 type ZfsDriver struct {
 	volume.Driver
 	rds *zfs.Dataset //root dataset
+
+	schedMu    sync.Mutex
+	schedulers map[string]*volumeScheduler
+
+	replMu   sync.Mutex
+	replJobs map[string]*replicationJob
+
+	mountMu      sync.Mutex
+	activeMounts map[string]map[string]struct{} // dataset name -> set of active req.ID mounters
+
+	idMaps *idMapProfiles
+}
+
+//Config holds the options NewZfsDriverWithConfig accepts to control pool
+//adoption/bootstrap and id-map profiles. Dataset is required; BackingFile,
+//PoolName and BackingFileSize only matter when the configured dataset's
+//pool isn't already imported.
+type Config struct {
+	Dataset         string
+	BackingFile     string //zfs.backing-file: file backing an adoptable/creatable pool
+	PoolName        string //zfs.pool-name: pool that owns Dataset
+	BackingFileSize string //size used when creating a fresh backing file, e.g. "10G"
+
+	IDMapUser       string //subuid/subgid username to load as a named uid-map/gid-map profile
+	IDMapConfigFile string //optional file defining additional named uid-map/gid-map profiles
 }
 
 //NewZfsDriver returns the plugin driver object
 func NewZfsDriver(ds string) (*ZfsDriver, error) {
+	return NewZfsDriverWithConfig(Config{Dataset: ds})
+}
+
+//NewZfsDriverWithConfig returns the plugin driver object, optionally
+//importing or bootstrapping a file-backed zpool first so the plugin can
+//survive host reboots where the pool was exported, or bootstrap a fresh
+//host with no pool at all.
+func NewZfsDriverWithConfig(cfg Config) (*ZfsDriver, error) {
 	log.Debug("Creating new ZfsDriver.")
-	zd := &ZfsDriver{}
-	if !zfs.DatasetExists(ds) {
-		_, err := zfs.CreateDatasetRecursive(ds, make(map[string]string))
+
+	if err := checkPrerequisites(); err != nil {
+		return nil, err
+	}
+
+	if err := ensurePool(cfg); err != nil {
+		log.WithError(err).Error("Failed to adopt or create zpool.")
+		return nil, err
+	}
+
+	idMaps, err := loadIDMapProfiles(cfg)
+	if err != nil {
+		log.WithError(err).Error("Failed to load id-map profiles.")
+		return nil, err
+	}
+
+	zd := &ZfsDriver{idMaps: idMaps}
+	if !zfs.DatasetExists(cfg.Dataset) {
+		_, err := zfs.CreateDatasetRecursive(cfg.Dataset, make(map[string]string))
 		if err != nil {
 			log.Error("Failed to create root dataset.")
 			return nil, err
 		}
 	}
-	rds, err := zfs.GetDataset(ds)
+	rds, err := zfs.GetDataset(cfg.Dataset)
 	if err != nil {
 		log.Error("Failed to get root dataset.")
 		return nil, err
 	}
 	zd.rds = rds
 
+	zd.loadSchedules()
+	zd.loadReplicationSchedules()
+
 	return zd, nil
 }
 
@@ -54,8 +107,144 @@ func (zd *ZfsDriver) Create(req *volume.CreateRequest) error {
 		return fmt.Errorf("volume already exists")
 	}
 
-	_, err := zfs.CreateDatasetRecursive(dsName, req.Options)
-	return err
+	cloneFrom, isClone := req.Options["clone-from"]
+	schedule, hasSchedule := req.Options["snapshot.schedule"]
+	replTarget, hasReplication := req.Options["replicate.target"]
+	replInterval := req.Options["replicate.interval"]
+	replKeep := req.Options["replicate.keep"]
+
+	reservedKeys := map[string]bool{
+		"clone-from":         true,
+		"snapshot.schedule":  true,
+		"replicate.target":   true,
+		"replicate.interval": true,
+		"replicate.keep":     true,
+	}
+	rest := make(map[string]string, len(req.Options))
+	for k, v := range req.Options {
+		if reservedKeys[k] {
+			continue
+		}
+		rest[k] = v
+	}
+
+	vo, err := parseVolumeOptions(rest)
+	if err != nil {
+		return err
+	}
+
+	// Validate everything we can before the dataset exists at all, so a
+	// typo'd snapshot.schedule/replicate.*/uid-map/gid-map option fails
+	// Create cleanly instead of leaving an orphaned, half-configured
+	// dataset behind that then trips the "volume already exists" guard
+	// above on retry.
+	if hasSchedule {
+		if _, err := parseSchedule(schedule); err != nil {
+			return fmt.Errorf("invalid snapshot.schedule: %w", err)
+		}
+	}
+	if hasReplication {
+		if _, err := parseReplicationConfig(replTarget, replInterval, replKeep); err != nil {
+			return fmt.Errorf("invalid replicate.* options: %w", err)
+		}
+	}
+	var uidRanges, gidRanges []idMapRange
+	if vo.uidMap != "" || vo.gidMap != "" {
+		uidRanges, gidRanges, err = zd.resolveIDMaps(vo.uidMap, vo.gidMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if isClone {
+		err = zd.cloneFrom(dsName, cloneFrom, vo.zfsProperties)
+	} else {
+		_, err = zfs.CreateDatasetRecursive(dsName, vo.zfsProperties)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := zd.applyCreatedVolumeSettings(req.Name, dsName, vo, uidRanges, gidRanges, schedule, hasSchedule, replTarget, replInterval, replKeep, hasReplication); err != nil {
+		zd.stopScheduler(req.Name)
+		zd.stopReplicationScheduler(req.Name)
+		if ds, gerr := zfs.GetDataset(dsName); gerr == nil {
+			if derr := ds.Destroy(); derr != nil {
+				log.WithError(derr).WithField("name", req.Name).Error("Failed to roll back half-configured volume after Create error")
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// applyCreatedVolumeSettings applies everything Create needs once the
+// dataset itself exists: mount-options/selinux-label/uid-map/gid-map/
+// readonly, then the snapshot schedule and replication config. Create
+// stops any scheduler this started and destroys the dataset if this
+// returns an error, so none of these steps need to be individually rolled
+// back.
+func (zd *ZfsDriver) applyCreatedVolumeSettings(volName, dsName string, vo *volumeOptions, uidRanges, gidRanges []idMapRange, schedule string, hasSchedule bool, replTarget, replInterval, replKeep string, hasReplication bool) error {
+	if vo.mountOptions != "" || vo.selinuxLabel != "" || vo.uidMap != "" || vo.gidMap != "" || vo.readonly {
+		ds, err := zfs.GetDataset(dsName)
+		if err != nil {
+			return err
+		}
+		if vo.mountOptions != "" {
+			if err := setProperty(dsName, mountOptsProperty, vo.mountOptions); err != nil {
+				return err
+			}
+		}
+		if vo.selinuxLabel != "" {
+			if err := setProperty(dsName, selinuxLabelProperty, vo.selinuxLabel); err != nil {
+				return err
+			}
+		}
+		if vo.uidMap != "" || vo.gidMap != "" {
+			if vo.uidMap != "" {
+				if err := setProperty(dsName, uidMapProperty, formatIDMapRanges(uidRanges)); err != nil {
+					return err
+				}
+			}
+			if vo.gidMap != "" {
+				if err := setProperty(dsName, gidMapProperty, formatIDMapRanges(gidRanges)); err != nil {
+					return err
+				}
+			}
+
+			mp, err := ds.GetMountpoint()
+			if err != nil {
+				return err
+			}
+			// Remap ownership before applying readonly below: once the
+			// dataset is read-only the chown pass would fail with EROFS.
+			if err := remapOwnership(mp, uidRanges, gidRanges); err != nil {
+				return err
+			}
+		}
+		if vo.readonly {
+			if err := setProperty(dsName, "readonly", "on"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if hasSchedule {
+		if err := zd.SetSchedule(volName, schedule); err != nil {
+			log.WithError(err).WithField("name", volName).Error("Failed to set snapshot schedule")
+			return err
+		}
+	}
+
+	if hasReplication {
+		if err := zd.SetReplication(volName, replTarget, replInterval, replKeep); err != nil {
+			log.WithError(err).WithField("name", volName).Error("Failed to set replication config")
+			return err
+		}
+	}
+
+	return nil
 }
 
 //List returns a list of zfs volumes on this host
@@ -107,13 +296,29 @@ func (zd *ZfsDriver) getVolume(name string) (*volume.Volume, error) {
 		return nil, err
 	}
 
+	v := &volume.Volume{Name: zd.unqalifyDatasetName(name), Mountpoint: mp}
+	status := make(map[string]interface{})
+	if repl := zd.replicationStatus(name); repl != nil {
+		status["replication"] = repl
+	}
+	if quota, err := ds.GetProperty("quota"); err == nil && quota != "" && quota != "-" {
+		status["size"] = quota
+	}
+	if readonly, err := ds.GetProperty("readonly"); err == nil && readonly == "on" {
+		status["readonly"] = true
+	}
+	if len(status) > 0 {
+		v.Status = status
+	}
+
 	ts, err := ds.GetCreation()
 	if err != nil {
 		log.WithError(err).Error("Failed to get creation property from zfs dataset")
-		return &volume.Volume{Name: zd.unqalifyDatasetName(name), Mountpoint: mp}, nil
+		return v, nil
 	}
+	v.CreatedAt = ts.Format(time.RFC3339)
 
-	return &volume.Volume{Name: zd.unqalifyDatasetName(name), Mountpoint: mp, CreatedAt: ts.Format(time.RFC3339)}, nil
+	return v, nil
 }
 
 func (zd *ZfsDriver) getMP(name string) (string, error) {
@@ -135,6 +340,9 @@ func (zd *ZfsDriver) Remove(req *volume.RemoveRequest) error {
 		return err
 	}
 
+	zd.stopScheduler(req.Name)
+	zd.stopReplicationScheduler(req.Name)
+
 	return ds.Destroy()
 }
 
@@ -153,7 +361,6 @@ func (zd *ZfsDriver) Path(req *volume.PathRequest) (*volume.PathResponse, error)
 }
 
 //Mount returns the mountpoint of the zfs volume
-//nolint: dupl
 func (zd *ZfsDriver) Mount(req *volume.MountRequest) (*volume.MountResponse, error) {
 	log.WithField("Request", req).Debug("Mount")
 	dsName := zd.qualifyDatasetName(req.Name)
@@ -163,13 +370,143 @@ func (zd *ZfsDriver) Mount(req *volume.MountRequest) (*volume.MountResponse, err
 		return nil, err
 	}
 
+	// Only the first container attaching to this volume actually needs to
+	// apply mount settings (in particular the mount-options bind mount,
+	// which stacks a new layer on the mount table every time it runs);
+	// later concurrent mounters just get the existing mountpoint back.
+	if zd.trackMount(dsName, req.ID) {
+		if err := zd.applyVolumeMountSettings(dsName, mp); err != nil {
+			zd.untrackMount(dsName, req.ID)
+			return nil, err
+		}
+	}
+
 	return &volume.MountResponse{Mountpoint: mp}, nil
 }
 
-//Unmount does nothing because a zfs dataset need not be unmounted
+// trackMount records id as an active mounter of dsName and reports whether
+// it's the first one, i.e. whether Mount needs to actually apply mount
+// settings rather than just handing back the existing mountpoint.
+func (zd *ZfsDriver) trackMount(dsName, id string) bool {
+	zd.mountMu.Lock()
+	defer zd.mountMu.Unlock()
+
+	if zd.activeMounts == nil {
+		zd.activeMounts = make(map[string]map[string]struct{})
+	}
+	ids, ok := zd.activeMounts[dsName]
+	if !ok {
+		ids = make(map[string]struct{})
+		zd.activeMounts[dsName] = ids
+	}
+
+	first := len(ids) == 0
+	ids[id] = struct{}{}
+	return first
+}
+
+// untrackMount removes id from dsName's active mounters and reports whether
+// that was the last one, i.e. whether Unmount needs to tear down whatever
+// Mount applied.
+func (zd *ZfsDriver) untrackMount(dsName, id string) bool {
+	zd.mountMu.Lock()
+	defer zd.mountMu.Unlock()
+
+	ids, ok := zd.activeMounts[dsName]
+	if !ok {
+		return true
+	}
+
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(zd.activeMounts, dsName)
+		return true
+	}
+	return false
+}
+
+// applyVolumeMountSettings re-applies a volume's persisted mount-options,
+// selinux-label and uid-map/gid-map whenever Mount finds no other active
+// mounter of the volume (see trackMount), since all three are
+// host-side state that doesn't survive a bind mount being torn down between
+// container runs, or drift from manual changes to the mountpoint.
+func (zd *ZfsDriver) applyVolumeMountSettings(dsName, mountpoint string) error {
+	ds, err := zfs.GetDataset(dsName)
+	if err != nil {
+		return err
+	}
+
+	if mountOpts, err := ds.GetProperty(mountOptsProperty); err == nil && mountOpts != "" && mountOpts != "-" {
+		if err := applyMountOptions(mountpoint, mountOpts); err != nil {
+			return err
+		}
+	}
+
+	if label, err := ds.GetProperty(selinuxLabelProperty); err == nil && label != "" && label != "-" {
+		if err := applySELinuxLabel(mountpoint, label); err != nil {
+			return err
+		}
+	}
+
+	uidMap, _ := ds.GetProperty(uidMapProperty)
+	gidMap, _ := ds.GetProperty(gidMapProperty)
+	if (uidMap != "" && uidMap != "-") || (gidMap != "" && gidMap != "-") {
+		var uidRanges, gidRanges []idMapRange
+		if uidMap != "" && uidMap != "-" {
+			if uidRanges, err = parseIDMapRanges(uidMap); err != nil {
+				return err
+			}
+		}
+		if gidMap != "" && gidMap != "-" {
+			if gidRanges, err = parseIDMapRanges(gidMap); err != nil {
+				return err
+			}
+		}
+		// The full recursive remap below is expensive on large volumes, so
+		// only run it when the mountpoint's own ownership shows drift; a
+		// volume that was already remapped on a previous Mount stays fast.
+		drifted, err := rootOwnershipDrifted(mountpoint, uidRanges, gidRanges)
+		if err != nil {
+			return err
+		}
+		if drifted {
+			if err := remapOwnership(mountpoint, uidRanges, gidRanges); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//Unmount tears down the bind mount Mount applied for mount-options, once
+//the last container using the volume has detached. The zfs dataset itself
+//is never unmounted here: only the bind mount applyMountOptions stacks on
+//top of it needs undoing, and only once nothing else is still using it.
 func (zd *ZfsDriver) Unmount(req *volume.UnmountRequest) error {
 	log.WithField("Request", req).Debug("Unmount")
-	return nil
+	dsName := zd.qualifyDatasetName(req.Name)
+
+	if !zd.untrackMount(dsName, req.ID) {
+		return nil
+	}
+
+	ds, err := zfs.GetDataset(dsName)
+	if err != nil {
+		return err
+	}
+
+	mountOpts, err := ds.GetProperty(mountOptsProperty)
+	if err != nil || mountOpts == "" || mountOpts == "-" {
+		return nil
+	}
+
+	mp, err := ds.GetMountpoint()
+	if err != nil {
+		return err
+	}
+
+	return removeMountOptions(mp)
 }
 
 //Capabilities sets the scope to local as this is a local only driver
@@ -0,0 +1,118 @@
+package zfsdriver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// idMapRange is one clause of a uid-map/gid-map, identical in shape to the
+// uidMaps/gidMaps entries Moby's zfs graphdriver takes: ContainerID..+Size
+// maps onto HostID..+Size.
+type idMapRange struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// parseIDMapRanges parses a literal "containerID:hostID:count,..." value,
+// as passed via the uid-map/gid-map create options.
+func parseIDMapRanges(s string) ([]idMapRange, error) {
+	var ranges []idMapRange
+	for _, clause := range strings.Split(s, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		parts := strings.Split(clause, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid id-map clause %q, want containerID:hostID:count", clause)
+		}
+
+		cID, err1 := strconv.Atoi(parts[0])
+		hID, err2 := strconv.Atoi(parts[1])
+		size, err3 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || err3 != nil || size < 1 {
+			return nil, fmt.Errorf("invalid id-map clause %q, want containerID:hostID:count", clause)
+		}
+
+		ranges = append(ranges, idMapRange{ContainerID: cID, HostID: hID, Size: size})
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("empty id-map")
+	}
+	return ranges, nil
+}
+
+// loadSubIDRanges reads /etc/subuid or /etc/subgid style entries
+// ("name:start:count") for the given name and returns them as ranges, one
+// per entry, mapping container ID 0 upward, matching how dockerd derives
+// uidMaps/gidMaps from those files.
+func loadSubIDRanges(path, name string) ([]idMapRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ranges []idMapRange
+	containerID := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 || parts[0] != name {
+			continue
+		}
+
+		start, err1 := strconv.Atoi(parts[1])
+		count, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		// A user can hold more than one subuid/subgid range; stack them
+		// contiguously in container-id space, same as dockerd does.
+		ranges = append(ranges, idMapRange{ContainerID: containerID, HostID: start, Size: count})
+		containerID += count
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no entry for %q in %s", name, path)
+	}
+	return ranges, nil
+}
+
+// translateID finds the range covering id and returns its host-side
+// translation. ok is false if id falls outside every range, in which case
+// the caller should leave ownership alone.
+func translateID(id int, ranges []idMapRange) (translated int, ok bool) {
+	for _, r := range ranges {
+		if id >= r.ContainerID && id < r.ContainerID+r.Size {
+			return r.HostID + (id - r.ContainerID), true
+		}
+	}
+	return 0, false
+}
+
+// formatIDMapRanges is the inverse of parseIDMapRanges, used to persist a
+// resolved id-map (profile or literal) back as a ZFS property so Mount can
+// reapply it without re-resolving the profile.
+func formatIDMapRanges(ranges []idMapRange) string {
+	clauses := make([]string, len(ranges))
+	for i, r := range ranges {
+		clauses[i] = fmt.Sprintf("%d:%d:%d", r.ContainerID, r.HostID, r.Size)
+	}
+	return strings.Join(clauses, ",")
+}
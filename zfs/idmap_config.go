@@ -0,0 +1,120 @@
+package zfsdriver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// idMapProfiles resolves the uid-map/gid-map create option, which can be
+// either literal "containerID:hostID:count,..." ranges or the name of a
+// profile loaded at driver construction time.
+type idMapProfiles struct {
+	uid map[string][]idMapRange
+	gid map[string][]idMapRange
+}
+
+// loadIDMapProfiles builds the set of named id-map profiles available to
+// Create, from /etc/subuid and /etc/subgid (when cfg.IDMapUser is set)
+// and/or an explicit config file (when cfg.IDMapConfigFile is set).
+//
+// The config file format is one profile clause per line:
+//
+//	<profile> <uid|gid> <containerID>:<hostID>:<count>[,<containerID>:<hostID>:<count>...]
+func loadIDMapProfiles(cfg Config) (*idMapProfiles, error) {
+	p := &idMapProfiles{uid: map[string][]idMapRange{}, gid: map[string][]idMapRange{}}
+
+	if cfg.IDMapUser != "" {
+		uidRanges, err := loadSubIDRanges("/etc/subuid", cfg.IDMapUser)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load uid-map profile %q: %w", cfg.IDMapUser, err)
+		}
+		gidRanges, err := loadSubIDRanges("/etc/subgid", cfg.IDMapUser)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load gid-map profile %q: %w", cfg.IDMapUser, err)
+		}
+		p.uid[cfg.IDMapUser] = uidRanges
+		p.gid[cfg.IDMapUser] = gidRanges
+	}
+
+	if cfg.IDMapConfigFile != "" {
+		if err := p.loadConfigFile(cfg.IDMapConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *idMapProfiles) loadConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open id-map config file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid id-map config line %q", line)
+		}
+
+		profile, kind, ranges := fields[0], fields[1], fields[2]
+		parsed, err := parseIDMapRanges(ranges)
+		if err != nil {
+			return fmt.Errorf("invalid id-map config line %q: %w", line, err)
+		}
+
+		switch kind {
+		case "uid":
+			p.uid[profile] = parsed
+		case "gid":
+			p.gid[profile] = parsed
+		default:
+			return fmt.Errorf("invalid id-map config line %q: kind must be uid or gid", line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// resolveIDMaps resolves a Create call's uid-map/gid-map option values
+// (each either literal ranges or a named profile) against the driver's
+// loaded profiles. An empty value resolves to a nil range, leaving that
+// axis of ownership untouched.
+func (zd *ZfsDriver) resolveIDMaps(uidMap, gidMap string) (uidRanges, gidRanges []idMapRange, err error) {
+	if uidMap != "" {
+		uidRanges, err = resolve(uidMap, zd.idMaps.uid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid uid-map: %w", err)
+		}
+	}
+	if gidMap != "" {
+		gidRanges, err = resolve(gidMap, zd.idMaps.gid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gid-map: %w", err)
+		}
+	}
+	return uidRanges, gidRanges, nil
+}
+
+// resolve returns the ranges for raw, which is either literal
+// "containerID:hostID:count,..." ranges or a profile name.
+func resolve(raw string, profiles map[string][]idMapRange) ([]idMapRange, error) {
+	if strings.Contains(raw, ":") {
+		return parseIDMapRanges(raw)
+	}
+
+	ranges, ok := profiles[raw]
+	if !ok {
+		return nil, fmt.Errorf("unknown id-map profile %q", raw)
+	}
+	return ranges, nil
+}
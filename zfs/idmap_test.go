@@ -0,0 +1,63 @@
+package zfsdriver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIDMapRanges(t *testing.T) {
+	ranges, err := parseIDMapRanges("0:100000:65536,65536:200000:1000")
+	if err != nil {
+		t.Fatalf("parseIDMapRanges returned error: %v", err)
+	}
+	want := []idMapRange{
+		{ContainerID: 0, HostID: 100000, Size: 65536},
+		{ContainerID: 65536, HostID: 200000, Size: 1000},
+	}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("parseIDMapRanges = %+v, want %+v", ranges, want)
+	}
+
+	cases := []string{"", "bogus", "0:100000", "0:100000:0", "a:b:c"}
+	for _, c := range cases {
+		if _, err := parseIDMapRanges(c); err == nil {
+			t.Errorf("parseIDMapRanges(%q) expected error, got nil", c)
+		}
+	}
+}
+
+func TestTranslateID(t *testing.T) {
+	ranges := []idMapRange{
+		{ContainerID: 0, HostID: 100000, Size: 65536},
+		{ContainerID: 65536, HostID: 200000, Size: 1000},
+	}
+
+	if got, ok := translateID(0, ranges); !ok || got != 100000 {
+		t.Errorf("translateID(0) = (%d, %v), want (100000, true)", got, ok)
+	}
+	if got, ok := translateID(100, ranges); !ok || got != 100100 {
+		t.Errorf("translateID(100) = (%d, %v), want (100100, true)", got, ok)
+	}
+	if got, ok := translateID(65536, ranges); !ok || got != 200000 {
+		t.Errorf("translateID(65536) = (%d, %v), want (200000, true)", got, ok)
+	}
+	if _, ok := translateID(70000, ranges); ok {
+		t.Errorf("translateID(70000) expected ok=false, id falls outside every range")
+	}
+}
+
+func TestFormatIDMapRangesRoundTrip(t *testing.T) {
+	ranges, err := parseIDMapRanges("0:100000:65536,65536:200000:1000")
+	if err != nil {
+		t.Fatalf("parseIDMapRanges returned error: %v", err)
+	}
+
+	formatted := formatIDMapRanges(ranges)
+	roundTripped, err := parseIDMapRanges(formatted)
+	if err != nil {
+		t.Fatalf("parseIDMapRanges(formatIDMapRanges(ranges)) returned error: %v", err)
+	}
+	if !reflect.DeepEqual(roundTripped, ranges) {
+		t.Fatalf("round-tripped ranges = %+v, want %+v", roundTripped, ranges)
+	}
+}
@@ -0,0 +1,68 @@
+package zfsdriver
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// remapOwnership walks root, translating each entry's owning uid/gid
+// through uidRanges/gidRanges and chowning it if the translation differs
+// from its current owner. IDs outside every range are left untouched. This
+// is what makes a volume usable from a userns-remapped Docker daemon: ZFS
+// datasets are otherwise owned by root on the host and show up as `nobody`
+// inside the container.
+func remapOwnership(root string, uidRanges, gidRanges []idMapRange) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		uid, gid := int(stat.Uid), int(stat.Gid)
+		newUID, uidChanged := translateID(uid, uidRanges)
+		newGID, gidChanged := translateID(gid, gidRanges)
+
+		if !uidChanged {
+			newUID = uid
+		}
+		if !gidChanged {
+			newGID = gid
+		}
+		if newUID == uid && newGID == gid {
+			return nil
+		}
+
+		return os.Lchown(path, newUID, newGID)
+	})
+}
+
+// rootOwnershipDrifted reports whether root's own ownership doesn't match
+// what uidRanges/gidRanges would translate it to. It's a cheap single-stat
+// check used to skip the expensive recursive remapOwnership walk on Mount
+// when nothing has changed since the last time ownership was fixed up.
+func rootOwnershipDrifted(root string, uidRanges, gidRanges []idMapRange) (bool, error) {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return false, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, nil
+	}
+
+	uid, gid := int(stat.Uid), int(stat.Gid)
+	if newUID, changed := translateID(uid, uidRanges); changed && newUID != uid {
+		return true, nil
+	}
+	if newGID, changed := translateID(gid, gidRanges); changed && newGID != gid {
+		return true, nil
+	}
+
+	return false, nil
+}
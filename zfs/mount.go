@@ -0,0 +1,75 @@
+package zfsdriver
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/selinux/go-selinux/label"
+)
+
+// mountOptionFlags maps the subset of comma-separated mount(8) options we
+// support in mount-options to their syscall.Mount flag, matching how
+// containers/storage's zfs driver interprets zfs.mountopt.
+var mountOptionFlags = map[string]uintptr{
+	"atime":   0,
+	"noatime": syscall.MS_NOATIME,
+	"dev":     0,
+	"nodev":   syscall.MS_NODEV,
+	"exec":    0,
+	"noexec":  syscall.MS_NOEXEC,
+	"suid":    0,
+	"nosuid":  syscall.MS_NOSUID,
+	"ro":      syscall.MS_RDONLY,
+	"rw":      0,
+}
+
+// applyMountOptions bind-mounts mountpoint over itself with the requested
+// options applied, since ZFS mountpoints otherwise come up with whatever
+// options the host's default mount policy uses.
+func applyMountOptions(mountpoint, options string) error {
+	if options == "" {
+		return nil
+	}
+
+	var flags uintptr
+	for _, opt := range strings.Split(options, ",") {
+		opt = strings.TrimSpace(opt)
+		flag, ok := mountOptionFlags[opt]
+		if !ok {
+			return fmt.Errorf("unsupported mount option %q", opt)
+		}
+		flags |= flag
+	}
+
+	if err := syscall.Mount(mountpoint, mountpoint, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed to bind mount %q: %w", mountpoint, err)
+	}
+	if err := syscall.Mount(mountpoint, mountpoint, "", syscall.MS_REMOUNT|syscall.MS_BIND|flags, ""); err != nil {
+		return fmt.Errorf("failed to apply mount options to %q: %w", mountpoint, err)
+	}
+
+	return nil
+}
+
+// applySELinuxLabel relabels mountpoint so containers with SELinux
+// enforcement enabled can access the volume.
+func applySELinuxLabel(mountpoint, fileLabel string) error {
+	if fileLabel == "" {
+		return nil
+	}
+
+	return label.Relabel(mountpoint, fileLabel, false)
+}
+
+// removeMountOptions undoes the bind mount applyMountOptions layered over
+// mountpoint. Only call this once the last active mounter of the volume has
+// detached (see ZfsDriver.untrackMount): the bind mount is shared by every
+// concurrent mounter, so unmounting it any earlier would pull it out from
+// under the others.
+func removeMountOptions(mountpoint string) error {
+	if err := syscall.Unmount(mountpoint, 0); err != nil {
+		return fmt.Errorf("failed to remove bind mount %q: %w", mountpoint, err)
+	}
+	return nil
+}
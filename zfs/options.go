@@ -0,0 +1,106 @@
+package zfsdriver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plugin-reserved create-time option keys. These are handled by ZfsDriver
+// itself rather than forwarded to zfs.CreateDatasetRecursive as native ZFS
+// properties, so they're parsed out before the rest of req.Options is
+// passed through.
+const (
+	optSize         = "size"          // -> quota/refquota
+	optMountOptions = "mount-options" // -> bind mount at Mount time
+	optSELinuxLabel = "selinux-label" // -> label.Relabel at Mount time
+	optReadonly     = "readonly"      // -> readonly zfs property
+	optUIDMap       = "uid-map"       // -> ownership remap at Create/Mount time
+	optGIDMap       = "gid-map"       // -> ownership remap at Create/Mount time
+)
+
+// mountOptsProperty and selinuxLabelProperty persist the plugin-level mount
+// options as ZFS user properties so Mount can re-apply them after a plugin
+// restart, the same way snapshot schedules and replication config persist.
+const (
+	mountOptsProperty    = "docker-zfs-plugin:mount-options"
+	selinuxLabelProperty = "docker-zfs-plugin:selinux-label"
+	uidMapProperty       = "docker-zfs-plugin:uid-map"
+	gidMapProperty       = "docker-zfs-plugin:gid-map"
+)
+
+// volumeOptions is the parsed, validated set of plugin-reserved options for
+// a Create call, plus whatever's left over to hand to ZFS verbatim.
+type volumeOptions struct {
+	size         string
+	mountOptions string
+	selinuxLabel string
+	readonly     bool
+	uidMap       string
+	gidMap       string
+
+	zfsProperties map[string]string
+}
+
+// parseVolumeOptions splits req.Options into plugin-reserved keys and
+// native ZFS properties, rejecting any ZFS property name that collides with
+// a plugin-managed one (quota/refquota when size is set). A "readonly" key
+// is always taken as the plugin option, so it never collides.
+func parseVolumeOptions(options map[string]string) (*volumeOptions, error) {
+	vo := &volumeOptions{zfsProperties: make(map[string]string, len(options))}
+
+	for k, v := range options {
+		switch k {
+		case optSize:
+			vo.size = v
+		case optMountOptions:
+			vo.mountOptions = v
+		case optSELinuxLabel:
+			vo.selinuxLabel = v
+		case optReadonly:
+			readonly, err := parseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid readonly value %q: %w", v, err)
+			}
+			vo.readonly = readonly
+		case optUIDMap:
+			vo.uidMap = v
+		case optGIDMap:
+			vo.gidMap = v
+		default:
+			vo.zfsProperties[k] = v
+		}
+	}
+
+	if vo.size != "" {
+		if _, ok := vo.zfsProperties["quota"]; ok {
+			return nil, fmt.Errorf("size option collides with explicit quota property")
+		}
+		if _, ok := vo.zfsProperties["refquota"]; ok {
+			return nil, fmt.Errorf("size option collides with explicit refquota property")
+		}
+		vo.zfsProperties["quota"] = vo.size
+		vo.zfsProperties["refquota"] = vo.size
+	}
+
+	// Unlike size above, there's no readonly-collision check to write here:
+	// the case optReadonly branch already consumes the "readonly" key
+	// before this point, so it can never also land in vo.zfsProperties.
+	//
+	// readonly is applied after the dataset is created and its ownership
+	// remapped (see Create), rather than folded into zfsProperties here,
+	// since creating the dataset read-only up front would make any
+	// uid-map/gid-map chown pass fail with EROFS.
+
+	return vo, nil
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "true", "on", "1", "yes":
+		return true, nil
+	case "false", "off", "0", "no", "":
+		return false, nil
+	default:
+		return false, fmt.Errorf("not a boolean")
+	}
+}
@@ -0,0 +1,48 @@
+package zfsdriver
+
+import "testing"
+
+func TestParseVolumeOptionsSplitsReservedKeys(t *testing.T) {
+	vo, err := parseVolumeOptions(map[string]string{
+		"size":          "10G",
+		"mount-options": "noatime,nodev",
+		"compression":   "lz4",
+	})
+	if err != nil {
+		t.Fatalf("parseVolumeOptions returned error: %v", err)
+	}
+	if vo.size != "10G" || vo.mountOptions != "noatime,nodev" {
+		t.Errorf("unexpected parsed options: %+v", vo)
+	}
+	if vo.zfsProperties["compression"] != "lz4" {
+		t.Errorf("expected compression to pass through to zfsProperties, got %+v", vo.zfsProperties)
+	}
+	if _, ok := vo.zfsProperties["size"]; ok {
+		t.Errorf("size should not leak into zfsProperties verbatim")
+	}
+}
+
+func TestParseVolumeOptionsRejectsSizeCollision(t *testing.T) {
+	cases := []map[string]string{
+		{"size": "10G", "quota": "5G"},
+		{"size": "10G", "refquota": "5G"},
+	}
+	for _, options := range cases {
+		if _, err := parseVolumeOptions(options); err == nil {
+			t.Errorf("parseVolumeOptions(%+v) expected collision error, got nil", options)
+		}
+	}
+}
+
+func TestParseVolumeOptionsReadonly(t *testing.T) {
+	vo, err := parseVolumeOptions(map[string]string{"readonly": "true"})
+	if err != nil {
+		t.Fatalf("parseVolumeOptions returned error: %v", err)
+	}
+	if !vo.readonly {
+		t.Errorf("expected readonly to be true")
+	}
+	if _, ok := vo.zfsProperties["readonly"]; ok {
+		t.Errorf("readonly should never land in zfsProperties, it's always consumed by the switch")
+	}
+}
@@ -0,0 +1,80 @@
+package zfsdriver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultBackingFileSize is used when a fresh file-backed pool is created
+// and Config.BackingFileSize is unset.
+const defaultBackingFileSize = "10G"
+
+// ensurePool makes sure cfg.PoolName is imported, so that cfg.Dataset can be
+// created or opened underneath it. It's a no-op unless Config.BackingFile is
+// set.
+//
+// If the pool isn't currently imported but the backing file already exists,
+// the pool is imported from it (the host rebooted and the pool was exported
+// cleanly). If the backing file doesn't exist either, a new sparse file and
+// pool are created, so a fresh host can bootstrap without a manual `zpool
+// create`.
+func ensurePool(cfg Config) error {
+	if cfg.BackingFile == "" {
+		return nil
+	}
+	if cfg.PoolName == "" {
+		return fmt.Errorf("zfs.pool-name must be set when zfs.backing-file is used")
+	}
+
+	if poolImported(cfg.PoolName) {
+		return nil
+	}
+
+	if _, err := os.Stat(cfg.BackingFile); err == nil {
+		return importPool(cfg.PoolName, cfg.BackingFile)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat backing file %q: %w", cfg.BackingFile, err)
+	}
+
+	return createBackedPool(cfg.PoolName, cfg.BackingFile, cfg.BackingFileSize)
+}
+
+func poolImported(pool string) bool {
+	return exec.Command("zpool", "list", pool).Run() == nil
+}
+
+func importPool(pool, backingFile string) error {
+	log.WithField("pool", pool).Info("Importing orphaned zpool from backing file")
+	dir := filepath.Dir(backingFile)
+	out, err := exec.Command("zpool", "import", "-d", dir, pool).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zpool import failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+func createBackedPool(pool, backingFile, size string) error {
+	if size == "" {
+		size = defaultBackingFileSize
+	}
+
+	log.WithField("pool", pool).WithField("file", backingFile).Info("Creating new file-backed zpool")
+
+	if err := os.MkdirAll(filepath.Dir(backingFile), 0700); err != nil {
+		return fmt.Errorf("failed to create backing file directory: %w", err)
+	}
+
+	if out, err := exec.Command("truncate", "-s", size, backingFile).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to allocate backing file: %w: %s", err, out)
+	}
+
+	if out, err := exec.Command("zpool", "create", pool, backingFile).CombinedOutput(); err != nil {
+		return fmt.Errorf("zpool create failed: %w: %s", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package zfsdriver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ErrPrerequisites is returned by NewZfsDriver when the host is missing
+// something the plugin needs to talk to ZFS, e.g. the `zfs` CLI or
+// /dev/zfs. main can check for it with errors.Is and exit cleanly instead
+// of panicking on the first ZFS call, mirroring the checks Moby's zfs
+// graphdriver runs before it lets the daemon start.
+var ErrPrerequisites = errors.New("zfs prerequisites not met")
+
+func checkPrerequisites() error {
+	if _, err := exec.LookPath("zfs"); err != nil {
+		return fmt.Errorf("%w: zfs binary not found in PATH: %v", ErrPrerequisites, err)
+	}
+
+	f, err := os.OpenFile("/dev/zfs", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("%w: cannot open /dev/zfs: %v", ErrPrerequisites, err)
+	}
+	f.Close()
+
+	return nil
+}
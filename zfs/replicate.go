@@ -0,0 +1,360 @@
+package zfsdriver
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clinta/go-zfs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replication configuration and status are persisted as ZFS user properties
+// on the volume's dataset so they survive plugin restarts, the same way
+// snapshot schedules are in snapshot.go.
+const (
+	replTargetProperty      = "docker-zfs-plugin:replicate-target"
+	replIntervalProperty    = "docker-zfs-plugin:replicate-interval"
+	replKeepProperty        = "docker-zfs-plugin:replicate-keep"
+	replLastSnapProperty    = "docker-zfs-plugin:replicate-last-snapshot"
+	replLastSyncProperty    = "docker-zfs-plugin:replicate-last-sync"
+	replBytesSentProperty   = "docker-zfs-plugin:replicate-bytes-sent"
+	replResumeTokenProperty = "docker-zfs-plugin:replicate-resume-token"
+	replPendingSnapProperty = "docker-zfs-plugin:replicate-pending-snapshot"
+
+	replSnapshotLabel = "repl"
+)
+
+// replicationConfig is a volume's parsed replicate.* create options.
+type replicationConfig struct {
+	target   *url.URL
+	interval time.Duration
+	keep     int
+}
+
+func parseReplicationConfig(target, interval, keep string) (*replicationConfig, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replicate.target %q: %w", target, err)
+	}
+	if u.Scheme != "ssh" && u.Scheme != "zfs" {
+		return nil, fmt.Errorf("replicate.target scheme must be ssh or zfs, got %q", u.Scheme)
+	}
+
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replicate.interval %q: %w", interval, err)
+	}
+
+	k, err := strconv.Atoi(keep)
+	if err != nil || k < 1 {
+		return nil, fmt.Errorf("invalid replicate.keep %q", keep)
+	}
+
+	return &replicationConfig{target: u, interval: d, keep: k}, nil
+}
+
+// ReplicationStatus is surfaced in volume.Volume.Status so `docker volume
+// inspect` can show replication health.
+type ReplicationStatus struct {
+	Target    string `json:"target"`
+	LastSync  string `json:"last_sync,omitempty"`
+	Lag       string `json:"lag,omitempty"`
+	BytesSent int64  `json:"bytes_sent,omitempty"`
+}
+
+// SetReplication validates and persists a volume's replication config, then
+// (re)starts its replication scheduler goroutine.
+func (zd *ZfsDriver) SetReplication(volName, target, interval, keep string) error {
+	cfg, err := parseReplicationConfig(target, interval, keep)
+	if err != nil {
+		return err
+	}
+
+	dsName := zd.qualifyDatasetName(volName)
+	if _, err := zfs.GetDataset(dsName); err != nil {
+		return err
+	}
+
+	for prop, val := range map[string]string{
+		replTargetProperty:   target,
+		replIntervalProperty: interval,
+		replKeepProperty:     keep,
+	} {
+		if err := setProperty(dsName, prop, val); err != nil {
+			return err
+		}
+	}
+
+	zd.startReplicationScheduler(volName, cfg)
+	return nil
+}
+
+// loadReplicationSchedules restarts a replication job for every volume that
+// has a persisted replicate.target, so syncing continues across restarts.
+func (zd *ZfsDriver) loadReplicationSchedules() {
+	dsl, err := zd.rds.DatasetList()
+	if err != nil {
+		log.WithError(err).Error("Failed to list datasets while loading replication schedules")
+		return
+	}
+
+	for _, ds := range dsl {
+		target, err := ds.GetProperty(replTargetProperty)
+		if err != nil || target == "" || target == "-" {
+			continue
+		}
+
+		interval := mustGetProperty(&ds, replIntervalProperty)
+		keep := mustGetProperty(&ds, replKeepProperty)
+		cfg, err := parseReplicationConfig(target, interval, keep)
+		if err != nil {
+			log.WithError(err).WithField("dataset", ds.Name).Error("Ignoring invalid persisted replication config")
+			continue
+		}
+
+		zd.startReplicationScheduler(zd.unqalifyDatasetName(ds.Name), cfg)
+	}
+}
+
+// replicationStatus reads the persisted replication status properties for a
+// volume, returning nil if replication isn't configured.
+func (zd *ZfsDriver) replicationStatus(dsName string) *ReplicationStatus {
+	ds, err := zfs.GetDataset(dsName)
+	if err != nil {
+		return nil
+	}
+
+	target, err := ds.GetProperty(replTargetProperty)
+	if err != nil || target == "" || target == "-" {
+		return nil
+	}
+
+	status := &ReplicationStatus{Target: target}
+	if lastSync, err := ds.GetProperty(replLastSyncProperty); err == nil && lastSync != "-" {
+		status.LastSync = lastSync
+		if t, err := time.Parse(time.RFC3339, lastSync); err == nil {
+			status.Lag = time.Since(t).Round(time.Second).String()
+		}
+	}
+	if bytesSent, err := ds.GetProperty(replBytesSentProperty); err == nil {
+		if n, err := strconv.ParseInt(bytesSent, 10, 64); err == nil {
+			status.BytesSent = n
+		}
+	}
+
+	return status
+}
+
+// Replicate runs one replication cycle for volName: take a replication
+// snapshot, send it incrementally (or in full, if there's no common base)
+// to the configured target, and prune old replication snapshots.
+//
+// If the destination has diverged from our last known-good replicated
+// snapshot, Replicate refuses to proceed unless force is true, in which
+// case the destination is rolled back with `zfs receive -F`.
+//
+// If the previous call left a partial send behind (the receiver reported a
+// receive_resume_token), Replicate resumes that send with `zfs send -t`
+// instead of taking a new snapshot, so an interrupted transfer of a large
+// snapshot doesn't have to restart from scratch.
+func (zd *ZfsDriver) Replicate(volName string, force bool) error {
+	dsName := zd.qualifyDatasetName(volName)
+	ds, err := zfs.GetDataset(dsName)
+	if err != nil {
+		return err
+	}
+
+	target, err := ds.GetProperty(replTargetProperty)
+	if err != nil || target == "" || target == "-" {
+		return fmt.Errorf("volume %q is not configured for replication", volName)
+	}
+	cfg, err := parseReplicationConfig(target, mustGetProperty(ds, replIntervalProperty), mustGetProperty(ds, replKeepProperty))
+	if err != nil {
+		return err
+	}
+
+	lastSnap, _ := ds.GetProperty(replLastSnapProperty)
+	if lastSnap == "-" {
+		// Never replicated: no common base, so sendSnapshot does a full send.
+		lastSnap = ""
+	}
+	if lastSnap != "" && lastSnap != "-" && !force {
+		if diverged, err := zd.destinationDiverged(cfg.target, dsName, lastSnap); err != nil {
+			log.WithError(err).WithField("volume", volName).Warn("Could not verify destination state before replicating")
+		} else if diverged {
+			return fmt.Errorf("destination for volume %q has diverged from %q; retry with force to roll it back", volName, lastSnap)
+		}
+	}
+
+	resumeToken := mustGetProperty(ds, replResumeTokenProperty)
+	pendingSnap := mustGetProperty(ds, replPendingSnapProperty)
+
+	var snapName string
+	if resumeToken != "" && resumeToken != "-" && pendingSnap != "" && pendingSnap != "-" {
+		// A previous attempt already took this snapshot and got partway
+		// through sending it; resume that send instead of taking a new one.
+		snapName = pendingSnap
+		log.WithField("volume", volName).Info("Resuming interrupted replication send")
+	} else {
+		resumeToken = ""
+		now := time.Now()
+		snapName = snapshotName(replSnapshotLabel, now)
+		if _, err := ds.Snapshot(snapName); err != nil {
+			return fmt.Errorf("failed to take replication snapshot: %w", err)
+		}
+	}
+
+	bytesSent, newResumeToken, err := zd.sendSnapshot(cfg.target, dsName, lastSnap, snapName, resumeToken, force)
+	if err != nil {
+		if newResumeToken != "" {
+			if serr := setProperty(dsName, replResumeTokenProperty, newResumeToken); serr != nil {
+				log.WithError(serr).WithField("volume", volName).Error("Failed to persist replication resume token")
+			}
+			if serr := setProperty(dsName, replPendingSnapProperty, snapName); serr != nil {
+				log.WithError(serr).WithField("volume", volName).Error("Failed to persist pending replication snapshot")
+			}
+		}
+		return fmt.Errorf("failed to replicate volume %q: %w", volName, err)
+	}
+
+	// The send completed; clear any resume state left over from a prior
+	// failed attempt.
+	if err := setProperty(dsName, replResumeTokenProperty, "-"); err != nil {
+		return err
+	}
+	if err := setProperty(dsName, replPendingSnapProperty, "-"); err != nil {
+		return err
+	}
+
+	if err := setProperty(dsName, replLastSnapProperty, snapName); err != nil {
+		return err
+	}
+	if err := setProperty(dsName, replLastSyncProperty, time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if err := setProperty(dsName, replBytesSentProperty, strconv.FormatInt(bytesSent, 10)); err != nil {
+		return err
+	}
+
+	return zd.pruneSnapshots(volName, retentionPeriod{label: replSnapshotLabel, keep: cfg.keep})
+}
+
+// sendSnapshot streams dsName@snap (incrementally from base, if base is
+// non-empty, or resuming resumeToken, if that's non-empty) to the
+// configured target, returning the number of bytes sent and, if the send
+// failed partway through, a resume token for the next attempt. SSH targets
+// pipe through the system ssh client; zfs:// targets dial the receiving
+// plugin's own authenticated TCP listener directly.
+func (zd *ZfsDriver) sendSnapshot(target *url.URL, dsName, base, snap, resumeToken string, force bool) (int64, string, error) {
+	var sendArgs []string
+	if resumeToken != "" {
+		sendArgs = []string{"send", "-t", resumeToken}
+	} else {
+		sendArgs = []string{"send"}
+		if base != "" {
+			sendArgs = append(sendArgs, "-i", dsName+"@"+base)
+		}
+		sendArgs = append(sendArgs, dsName+"@"+snap)
+	}
+
+	remoteDataset := strings.TrimPrefix(target.Path, "/")
+	receiveArgs := []string{"receive", "-s"} // -s: leave a resume token behind if the stream is interrupted
+	if force {
+		receiveArgs = append(receiveArgs, "-F")
+	}
+	receiveArgs = append(receiveArgs, remoteDataset)
+
+	send := exec.Command("zfs", sendArgs...)
+	switch target.Scheme {
+	case "ssh":
+		return zd.sendOverSSH(target, send, receiveArgs)
+	case "zfs":
+		return zd.sendOverListener(target, send, receiveArgs)
+	default:
+		return 0, "", fmt.Errorf("unsupported replicate target scheme %q", target.Scheme)
+	}
+}
+
+// sendOverSSH pipes send's output into `zfs receive` on target.Host over
+// the system ssh client.
+func (zd *ZfsDriver) sendOverSSH(target *url.URL, send *exec.Cmd, receiveArgs []string) (int64, string, error) {
+	sshArgs := append([]string{target.Host}, append([]string{"zfs"}, receiveArgs...)...)
+	receive := exec.Command("ssh", sshArgs...)
+
+	pipe, err := send.StdoutPipe()
+	if err != nil {
+		return 0, "", err
+	}
+	receive.Stdin = pipe
+
+	var sentBuf bytes.Buffer
+	receive.Stderr = &sentBuf
+
+	if err := receive.Start(); err != nil {
+		return 0, "", err
+	}
+	if err := send.Run(); err != nil {
+		return 0, "", fmt.Errorf("zfs send failed: %w", err)
+	}
+	if err := receive.Wait(); err != nil {
+		dataset := receiveArgs[len(receiveArgs)-1]
+		return 0, sshResumeToken(target.Host, dataset), fmt.Errorf("zfs receive failed: %w: %s", err, sentBuf.String())
+	}
+
+	return int64(sentBuf.Len()), "", nil
+}
+
+// sshResumeToken best-effort queries the remote's receive_resume_token
+// after a failed receive over ssh. Errors are swallowed since this already
+// runs on a failure path; a missing token just means the next Replicate
+// call falls back to taking a brand new snapshot.
+func sshResumeToken(host, dataset string) string {
+	out, err := exec.Command("ssh", host, "zfs", "get", "-H", "-o", "value", "receive_resume_token", dataset).Output()
+	if err != nil {
+		return ""
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "-" {
+		return ""
+	}
+	return token
+}
+
+// destinationDiverged checks, over the target's transport, whether the
+// destination's latest snapshot matches our last known-good replicated
+// snapshot.
+func (zd *ZfsDriver) destinationDiverged(target *url.URL, dsName, lastSnap string) (bool, error) {
+	remoteDataset := strings.TrimPrefix(target.Path, "/")
+
+	switch target.Scheme {
+	case "ssh":
+		out, err := exec.Command("ssh", target.Host, "zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-d", "1", "-s", "creation", remoteDataset).Output()
+		if err != nil {
+			return false, err
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		return lines[len(lines)-1] != remoteDataset+"@"+lastSnap, nil
+	case "zfs":
+		last, err := queryLastSnapshot(target, target.User.String(), remoteDataset)
+		if err != nil {
+			return false, err
+		}
+		return last != lastSnap, nil
+	default:
+		return false, fmt.Errorf("unsupported replicate target scheme %q", target.Scheme)
+	}
+}
+
+func mustGetProperty(ds *zfs.Dataset, prop string) string {
+	v, err := ds.GetProperty(prop)
+	if err != nil {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,267 @@
+package zfsdriver
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplicationListener accepts incoming `zfs send` streams from peer plugins
+// configured with a "zfs://" replicate.target, authenticates them with a
+// shared token, and pipes the stream into a local `zfs receive`.
+type ReplicationListener struct {
+	listener net.Listener
+	token    string
+}
+
+// ListenReplication starts an authenticated TCP listener for inbound
+// replication streams. The token must match the one peers present, and is
+// typically distributed out-of-band (e.g. via the plugin's config file).
+func ListenReplication(addr, token string) (*ReplicationListener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := &ReplicationListener{listener: l, token: token}
+	go rl.serve()
+	return rl, nil
+}
+
+// Close stops accepting new replication connections.
+func (rl *ReplicationListener) Close() error {
+	return rl.listener.Close()
+}
+
+func (rl *ReplicationListener) serve() {
+	for {
+		conn, err := rl.listener.Accept()
+		if err != nil {
+			log.WithError(err).Debug("Replication listener stopped accepting connections")
+			return
+		}
+		go rl.handle(conn)
+	}
+}
+
+// wire protocol: a single newline-terminated header
+// "<token> <command> <dataset> [<flag>]", where command is RECV (followed
+// immediately by the raw zfs send stream) or LASTSNAP. Every connection
+// with a well-formed header and a valid token gets exactly one
+// newline-terminated response line back: "OK [<payload>]" or
+// "ERR <message>|<resume-token>", so the sender always learns whether the
+// peer's zfs receive actually succeeded rather than assuming so as soon as
+// its own zfs send exits. Connections with an unreadable or malformed
+// header or an invalid token are closed without a response.
+func (rl *ReplicationListener) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		log.WithError(err).Warn("Replication listener: failed to read header")
+		return
+	}
+
+	var token, cmd, dataset, flag string
+	n, _ := fmt.Sscanf(header, "%s %s %s %s", &token, &cmd, &dataset, &flag)
+	if n < 3 {
+		log.Warn("Replication listener: malformed header")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(rl.token)) != 1 {
+		log.Warn("Replication listener: rejected connection with invalid token")
+		return
+	}
+
+	switch cmd {
+	case "LASTSNAP":
+		rl.handleLastSnap(conn, dataset)
+	case "RECV":
+		rl.handleRecv(conn, reader, dataset, flag)
+	default:
+		log.WithField("command", cmd).Warn("Replication listener: unknown command")
+		fmt.Fprintf(conn, "ERR unknown command|-\n")
+	}
+}
+
+// handleLastSnap answers destinationDiverged's zfs:// equivalent of the
+// `zfs list` it runs over ssh: the dataset's most recent snapshot name, or
+// "-" if it has none.
+func (rl *ReplicationListener) handleLastSnap(conn net.Conn, dataset string) {
+	out, err := exec.Command("zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-d", "1", "-s", "creation", dataset).Output()
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %s|-\n", sanitizeForWire(err.Error()))
+		return
+	}
+
+	last := "-"
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if full := lines[len(lines)-1]; full != "" {
+		if idx := strings.LastIndex(full, "@"); idx >= 0 {
+			last = full[idx+1:]
+		}
+	}
+	fmt.Fprintf(conn, "OK %s\n", last)
+}
+
+// handleRecv pipes stream into `zfs receive` and reports the outcome back
+// over conn, so a failed receive is never mistaken by the sender for a
+// successful sync. receive is always given -s so a partial failure leaves
+// a receive_resume_token behind, which is reported back alongside the
+// error to let the sender resume instead of restarting the whole stream.
+func (rl *ReplicationListener) handleRecv(conn net.Conn, stream io.Reader, dataset, flag string) {
+	args := []string{"receive", "-s"}
+	if flag == "-F" {
+		args = append(args, "-F")
+	}
+	args = append(args, dataset)
+
+	receive := exec.Command("zfs", args...)
+	receive.Stdin = stream
+	if out, err := receive.CombinedOutput(); err != nil {
+		resumeToken := receiveResumeToken(dataset)
+		log.WithError(err).WithField("output", string(out)).Error("Replication listener: zfs receive failed")
+		fmt.Fprintf(conn, "ERR %s|%s\n", sanitizeForWire(fmt.Sprintf("%v: %s", err, out)), resumeToken)
+		return
+	}
+
+	fmt.Fprintf(conn, "OK\n")
+}
+
+// receiveResumeToken best-effort queries dataset's receive_resume_token
+// after a failed receive. Returns "-" if there is none or the query fails,
+// which the caller's wire format already treats as "no token".
+func receiveResumeToken(dataset string) string {
+	out, err := exec.Command("zfs", "get", "-H", "-o", "value", "receive_resume_token", dataset).Output()
+	if err != nil {
+		return "-"
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "-"
+	}
+	return token
+}
+
+// sanitizeForWire collapses a message to the single line the wire protocol
+// uses and strips the "|" field separator so it can't be confused with the
+// resume-token field that follows it.
+func sanitizeForWire(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	return strings.ReplaceAll(s, "|", "/")
+}
+
+// countingWriter wraps a net.Conn to report exactly how many bytes of the
+// zfs send stream were written to it, for bytes_sent in Get's Status.
+type countingWriter struct {
+	w net.Conn
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readAck reads the listener's single-line response and splits it into its
+// status ("OK"/"ERR") and payload.
+func readAck(conn net.Conn) (status, payload string, err error) {
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	status = parts[0]
+	if len(parts) > 1 {
+		payload = parts[1]
+	}
+	return status, payload, nil
+}
+
+// splitAckPayload splits an ERR payload's "<message>|<resume-token>" shape.
+func splitAckPayload(payload string) (msg, resumeToken string) {
+	parts := strings.SplitN(payload, "|", 2)
+	msg = parts[0]
+	if len(parts) > 1 && parts[1] != "-" {
+		resumeToken = parts[1]
+	}
+	return msg, resumeToken
+}
+
+// sendOverListener dials a peer's authenticated replication listener and
+// streams send's output to it directly, bypassing ssh entirely. It blocks
+// until the peer's zfs receive has actually finished and reports its own
+// outcome back (see handleRecv), and returns the real number of bytes
+// written to the connection instead of assuming success from send alone.
+func (zd *ZfsDriver) sendOverListener(target *url.URL, send *exec.Cmd, receiveArgs []string) (int64, string, error) {
+	conn, err := net.Dial("tcp", target.Host)
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+
+	token := target.User.String()
+	dataset := receiveArgs[len(receiveArgs)-1]
+	flag := ""
+	for _, a := range receiveArgs {
+		if a == "-F" {
+			flag = "-F"
+		}
+	}
+	if _, err := fmt.Fprintf(conn, "%s RECV %s %s\n", token, dataset, flag); err != nil {
+		return 0, "", err
+	}
+
+	cw := &countingWriter{w: conn}
+	send.Stdout = cw
+	if err := send.Run(); err != nil {
+		return 0, "", fmt.Errorf("zfs send failed: %w", err)
+	}
+
+	status, payload, err := readAck(conn)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read replication ack: %w", err)
+	}
+	if status != "OK" {
+		msg, resumeToken := splitAckPayload(payload)
+		return 0, resumeToken, fmt.Errorf("remote zfs receive failed: %s", msg)
+	}
+
+	return cw.n, "", nil
+}
+
+// queryLastSnapshot asks a peer's replication listener for dataset's most
+// recent snapshot name, the zfs:// transport's equivalent of the `zfs list`
+// over ssh that destinationDiverged uses for the ssh transport.
+func queryLastSnapshot(target *url.URL, token, dataset string) (string, error) {
+	conn, err := net.Dial("tcp", target.Host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "%s LASTSNAP %s\n", token, dataset); err != nil {
+		return "", err
+	}
+
+	status, payload, err := readAck(conn)
+	if err != nil {
+		return "", err
+	}
+	if status != "OK" {
+		msg, _ := splitAckPayload(payload)
+		return "", fmt.Errorf("remote error: %s", msg)
+	}
+	return payload, nil
+}
@@ -0,0 +1,61 @@
+package zfsdriver
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type replicationJob struct {
+	volName string
+	cfg     *replicationConfig
+	stop    chan struct{}
+}
+
+// startReplicationScheduler stops any existing replication job for volName
+// and starts a new one running on cfg.interval.
+func (zd *ZfsDriver) startReplicationScheduler(volName string, cfg *replicationConfig) {
+	zd.replMu.Lock()
+	defer zd.replMu.Unlock()
+
+	if zd.replJobs == nil {
+		zd.replJobs = make(map[string]*replicationJob)
+	}
+
+	if existing, ok := zd.replJobs[volName]; ok {
+		close(existing.stop)
+	}
+
+	j := &replicationJob{volName: volName, cfg: cfg, stop: make(chan struct{})}
+	zd.replJobs[volName] = j
+	go zd.runReplicationJob(j)
+}
+
+// stopReplicationScheduler stops the replication job for a volume, if any.
+func (zd *ZfsDriver) stopReplicationScheduler(volName string) {
+	zd.replMu.Lock()
+	defer zd.replMu.Unlock()
+
+	if j, ok := zd.replJobs[volName]; ok {
+		close(j.stop)
+		delete(zd.replJobs, volName)
+	}
+}
+
+func (zd *ZfsDriver) runReplicationJob(j *replicationJob) {
+	log.WithField("volume", j.volName).Debug("Starting replication job")
+	ticker := time.NewTicker(j.cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			log.WithField("volume", j.volName).Debug("Stopping replication job")
+			return
+		case <-ticker.C:
+			if err := zd.Replicate(j.volName, false); err != nil {
+				log.WithError(err).WithField("volume", j.volName).Error("Scheduled replication failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package zfsdriver
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// volumeScheduler runs the periodic snapshot/retention loop for a single
+// volume.
+type volumeScheduler struct {
+	volName string
+	periods []retentionPeriod
+	stop    chan struct{}
+}
+
+// startScheduler stops any existing scheduler for volName and starts a new
+// one running the given retention periods.
+func (zd *ZfsDriver) startScheduler(volName string, periods []retentionPeriod) {
+	zd.schedMu.Lock()
+	defer zd.schedMu.Unlock()
+
+	if zd.schedulers == nil {
+		zd.schedulers = make(map[string]*volumeScheduler)
+	}
+
+	if existing, ok := zd.schedulers[volName]; ok {
+		close(existing.stop)
+	}
+
+	s := &volumeScheduler{volName: volName, periods: periods, stop: make(chan struct{})}
+	zd.schedulers[volName] = s
+	go zd.runScheduler(s)
+}
+
+// stopScheduler stops the scheduler goroutine for a volume, if any. Called
+// when a volume is removed.
+func (zd *ZfsDriver) stopScheduler(volName string) {
+	zd.schedMu.Lock()
+	defer zd.schedMu.Unlock()
+
+	if s, ok := zd.schedulers[volName]; ok {
+		close(s.stop)
+		delete(zd.schedulers, volName)
+	}
+}
+
+// schedulerTick is the minimum resolution at which schedules are evaluated.
+var schedulerTick = time.Minute
+
+func (zd *ZfsDriver) runScheduler(s *volumeScheduler) {
+	log.WithField("volume", s.volName).Debug("Starting snapshot scheduler")
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	last := make(map[string]time.Time, len(s.periods))
+
+	for {
+		select {
+		case <-s.stop:
+			log.WithField("volume", s.volName).Debug("Stopping snapshot scheduler")
+			return
+		case now := <-ticker.C:
+			for _, p := range s.periods {
+				if !now.After(last[p.label].Add(p.interval)) && !last[p.label].IsZero() {
+					continue
+				}
+
+				if _, err := zd.Snapshot(s.volName, snapshotName(p.label, now)); err != nil {
+					log.WithError(err).WithField("volume", s.volName).Error("Scheduled snapshot failed")
+					continue
+				}
+				last[p.label] = now
+
+				if err := zd.pruneSnapshots(s.volName, p); err != nil {
+					log.WithError(err).WithField("volume", s.volName).Error("Failed to prune old snapshots")
+				}
+			}
+		}
+	}
+}
+
+// pruneSnapshots destroys the oldest snapshots created by period p, those
+// beyond its retention count.
+func (zd *ZfsDriver) pruneSnapshots(volName string, p retentionPeriod) error {
+	snaps, err := zd.Snapshots(volName)
+	if err != nil {
+		return err
+	}
+
+	prefix := snapshotNamePrefix + "-" + p.label + "-"
+	var owned []*SnapshotInfo
+	for _, s := range snaps {
+		if len(s.Name) >= len(prefix) && s.Name[:len(prefix)] == prefix {
+			owned = append(owned, s)
+		}
+	}
+
+	for _, s := range snapshotsToPrune(owned, p.keep) {
+		if err := zd.DestroySnapshot(volName, s.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshotsToPrune returns the snapshots in owned beyond the keep count,
+// i.e. the oldest ones. owned is assumed newest-first, as Snapshots()
+// returns it, and the result keeps that newest-first order.
+func snapshotsToPrune(owned []*SnapshotInfo, keep int) []*SnapshotInfo {
+	if len(owned) <= keep {
+		return nil
+	}
+	return owned[keep:]
+}
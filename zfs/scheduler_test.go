@@ -0,0 +1,52 @@
+package zfsdriver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotsToPrune(t *testing.T) {
+	owned := []*SnapshotInfo{
+		{Name: "auto-daily-3"},
+		{Name: "auto-daily-2"},
+		{Name: "auto-daily-1"},
+		{Name: "auto-daily-0"},
+	}
+
+	pruned := snapshotsToPrune(owned, 2)
+	want := owned[2:]
+	if !reflect.DeepEqual(pruned, want) {
+		t.Fatalf("snapshotsToPrune(owned, 2) = %v, want %v", pruned, want)
+	}
+
+	if pruned := snapshotsToPrune(owned, len(owned)); pruned != nil {
+		t.Fatalf("snapshotsToPrune at exactly keep count = %v, want nil", pruned)
+	}
+
+	if pruned := snapshotsToPrune(owned, len(owned)+1); pruned != nil {
+		t.Fatalf("snapshotsToPrune under keep count = %v, want nil", pruned)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	periods, err := parseSchedule("hourly:24,daily:7")
+	if err != nil {
+		t.Fatalf("parseSchedule returned error: %v", err)
+	}
+	if len(periods) != 2 {
+		t.Fatalf("expected 2 periods, got %d", len(periods))
+	}
+	if periods[0].label != "hourly" || periods[0].keep != 24 {
+		t.Errorf("unexpected first period: %+v", periods[0])
+	}
+	if periods[1].label != "daily" || periods[1].keep != 7 {
+		t.Errorf("unexpected second period: %+v", periods[1])
+	}
+
+	cases := []string{"", "bogus", "hourly", "hourly:0", "hourly:abc"}
+	for _, c := range cases {
+		if _, err := parseSchedule(c); err == nil {
+			t.Errorf("parseSchedule(%q) expected error, got nil", c)
+		}
+	}
+}
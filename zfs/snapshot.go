@@ -0,0 +1,203 @@
+package zfsdriver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clinta/go-zfs"
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduleProperty is the ZFS user property used to persist a volume's
+// snapshot retention schedule across plugin restarts.
+const scheduleProperty = "docker-zfs-plugin:snapshot-schedule"
+
+// SnapshotInfo describes a single zfs snapshot of a volume's dataset.
+type SnapshotInfo struct {
+	Volume    string    `json:"Volume"`
+	Name      string    `json:"Name"`
+	CreatedAt time.Time `json:"CreatedAt"`
+}
+
+// retentionPeriod is one clause of a schedule, e.g. "daily:7".
+type retentionPeriod struct {
+	label    string
+	interval time.Duration
+	keep     int
+}
+
+var scheduleIntervals = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+}
+
+// parseSchedule parses a schedule string such as "hourly:24,daily:7,weekly:4"
+// into a list of retention periods.
+func parseSchedule(s string) ([]retentionPeriod, error) {
+	var periods []retentionPeriod
+	for _, clause := range strings.Split(s, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		parts := strings.SplitN(clause, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid schedule clause %q", clause)
+		}
+		interval, ok := scheduleIntervals[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule period %q", parts[0])
+		}
+		keep, err := strconv.Atoi(parts[1])
+		if err != nil || keep < 1 {
+			return nil, fmt.Errorf("invalid retention count in clause %q", clause)
+		}
+		periods = append(periods, retentionPeriod{label: parts[0], interval: interval, keep: keep})
+	}
+	if len(periods) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	return periods, nil
+}
+
+const snapshotNamePrefix = "auto"
+
+// snapshotName builds a timestamped snapshot name for a given retention period.
+func snapshotName(period string, t time.Time) string {
+	return fmt.Sprintf("%s-%s-%s", snapshotNamePrefix, period, t.UTC().Format("20060102T150405Z"))
+}
+
+// Snapshot takes an on-demand snapshot of a volume's dataset. If name is
+// empty, a timestamped name is generated.
+func (zd *ZfsDriver) Snapshot(volName, name string) (*SnapshotInfo, error) {
+	dsName := zd.qualifyDatasetName(volName)
+	ds, err := zfs.GetDataset(dsName)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = snapshotName("manual", time.Now())
+	}
+
+	if _, err := ds.Snapshot(name); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotInfo{Volume: volName, Name: name, CreatedAt: time.Now()}, nil
+}
+
+// Snapshots lists the snapshots of a volume's dataset, most recent first.
+func (zd *ZfsDriver) Snapshots(volName string) ([]*SnapshotInfo, error) {
+	dsName := zd.qualifyDatasetName(volName)
+	if _, err := zfs.GetDataset(dsName); err != nil {
+		return nil, err
+	}
+
+	names, err := datasetSnapshotNames(dsName)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*SnapshotInfo
+	for _, name := range names {
+		createdAt := time.Time{}
+		if ts, err := snapshotCreation(dsName + "@" + name); err == nil {
+			createdAt = ts
+		}
+		out = append(out, &SnapshotInfo{Volume: volName, Name: name, CreatedAt: createdAt})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Rollback rolls a volume's dataset back to the given snapshot, destroying
+// any snapshots created after it. Clones of those snapshots are not
+// destroyed.
+func (zd *ZfsDriver) Rollback(volName, snapName string) error {
+	dsName := zd.qualifyDatasetName(volName)
+	if _, err := zfs.GetSnapshot(dsName + "@" + snapName); err != nil {
+		return err
+	}
+
+	return rollbackDataset(dsName, snapName)
+}
+
+// DestroySnapshot destroys a single snapshot of a volume's dataset.
+func (zd *ZfsDriver) DestroySnapshot(volName, snapName string) error {
+	dsName := zd.qualifyDatasetName(volName)
+	snap, err := zfs.GetDataset(dsName + "@" + snapName)
+	if err != nil {
+		return err
+	}
+
+	return snap.Destroy()
+}
+
+// cloneFrom creates dsName as a clone of source, which must be of the form
+// "volume@snapshot".
+func (zd *ZfsDriver) cloneFrom(dsName, source string, properties map[string]string) error {
+	parts := strings.SplitN(source, "@", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("clone-from must be of the form volume@snapshot, got %q", source)
+	}
+
+	srcDs := zd.qualifyDatasetName(parts[0]) + "@" + parts[1]
+	if _, err := zfs.GetSnapshot(srcDs); err != nil {
+		return fmt.Errorf("failed to find source snapshot %q: %w", source, err)
+	}
+
+	return cloneSnapshot(srcDs, dsName, properties)
+}
+
+// SetSchedule validates and persists a snapshot retention schedule on a
+// volume's dataset, then (re)starts its scheduler goroutine.
+func (zd *ZfsDriver) SetSchedule(volName, schedule string) error {
+	periods, err := parseSchedule(schedule)
+	if err != nil {
+		return err
+	}
+
+	dsName := zd.qualifyDatasetName(volName)
+	if _, err := zfs.GetDataset(dsName); err != nil {
+		return err
+	}
+
+	if err := setProperty(dsName, scheduleProperty, schedule); err != nil {
+		return err
+	}
+
+	zd.startScheduler(volName, periods)
+	return nil
+}
+
+// loadSchedules restarts a scheduler goroutine for every volume that has a
+// persisted schedule, so retention continues across plugin restarts.
+func (zd *ZfsDriver) loadSchedules() {
+	dsl, err := zd.rds.DatasetList()
+	if err != nil {
+		log.WithError(err).Error("Failed to list datasets while loading snapshot schedules")
+		return
+	}
+
+	for _, ds := range dsl {
+		schedule, err := ds.GetProperty(scheduleProperty)
+		if err != nil || schedule == "" || schedule == "-" {
+			continue
+		}
+
+		periods, err := parseSchedule(schedule)
+		if err != nil {
+			log.WithError(err).WithField("dataset", ds.Name).Error("Ignoring invalid persisted snapshot schedule")
+			continue
+		}
+
+		zd.startScheduler(zd.unqalifyDatasetName(ds.Name), periods)
+	}
+}
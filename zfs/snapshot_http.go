@@ -0,0 +1,112 @@
+package zfsdriver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	log "github.com/sirupsen/logrus"
+)
+
+// Docker's volume plugin API has no snapshot verbs, so snapshot/clone/
+// rollback management is exposed over the same plugin socket under the
+// "/Snapshot.*" path prefix instead, for operators and scripts to drive
+// with curl rather than `docker volume`.
+
+type snapshotRequest struct {
+	Volume   string `json:"Volume"`
+	Name     string `json:"Name,omitempty"`
+	Schedule string `json:"Schedule,omitempty"`
+}
+
+type snapshotResponse struct {
+	Snapshot  *SnapshotInfo   `json:"Snapshot,omitempty"`
+	Snapshots []*SnapshotInfo `json:"Snapshots,omitempty"`
+	Err       string          `json:"Err,omitempty"`
+}
+
+func writeSnapshotResponse(w http.ResponseWriter, resp snapshotResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Err != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// RegisterSnapshotHandlers wires the "/Snapshot.*" HTTP endpoints onto a
+// volume.Handler's plugin socket, alongside the standard Docker volume API.
+func (zd *ZfsDriver) RegisterSnapshotHandlers(h *volume.Handler) {
+	h.HandleFunc("/Snapshot.Create", func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+
+		snap, err := zd.Snapshot(req.Volume, req.Name)
+		if err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+		writeSnapshotResponse(w, snapshotResponse{Snapshot: snap})
+	})
+
+	h.HandleFunc("/Snapshot.List", func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+
+		snaps, err := zd.Snapshots(req.Volume)
+		if err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+		writeSnapshotResponse(w, snapshotResponse{Snapshots: snaps})
+	})
+
+	h.HandleFunc("/Snapshot.Rollback", func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+
+		if err := zd.Rollback(req.Volume, req.Name); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+		writeSnapshotResponse(w, snapshotResponse{})
+	})
+
+	h.HandleFunc("/Snapshot.Remove", func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+
+		if err := zd.DestroySnapshot(req.Volume, req.Name); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+		writeSnapshotResponse(w, snapshotResponse{})
+	})
+
+	h.HandleFunc("/Snapshot.SetSchedule", func(w http.ResponseWriter, r *http.Request) {
+		var req snapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+
+		if err := zd.SetSchedule(req.Volume, req.Schedule); err != nil {
+			writeSnapshotResponse(w, snapshotResponse{Err: err.Error()})
+			return
+		}
+		writeSnapshotResponse(w, snapshotResponse{})
+	})
+
+	log.Debug("Registered /Snapshot.* HTTP sidecar routes")
+}
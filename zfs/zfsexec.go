@@ -0,0 +1,88 @@
+package zfsdriver
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// go-zfs only exposes property reads (GetProperty/GetExactProperty) and a
+// handful of dataset/snapshot operations; it has no property-write,
+// snapshot-listing, rollback, or clone-with-properties primitive. The
+// helpers below fill those gaps by shelling out directly, the same way
+// pool.go does for zpool operations the library doesn't cover.
+
+// setProperty sets a native or user-defined property on a dataset or
+// snapshot.
+func setProperty(name, prop, val string) error {
+	out, err := exec.Command("zfs", "set", prop+"="+val, name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs set %s failed: %w: %s", prop, err, out)
+	}
+	return nil
+}
+
+// datasetSnapshotNames lists the snapshots of dsName, oldest first, as the
+// name part after '@'.
+func datasetSnapshotNames(dsName string) ([]string, error) {
+	out, err := exec.Command("zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-d", "1", "-s", "creation", dsName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("zfs list snapshots failed: %w", err)
+	}
+
+	trimmed := strings.TrimSpace(string(out))
+	if trimmed == "" {
+		return nil, nil
+	}
+
+	var names []string
+	for _, line := range strings.Split(trimmed, "\n") {
+		names = append(names, line[strings.Index(line, "@")+1:])
+	}
+	return names, nil
+}
+
+// snapshotCreation returns a snapshot's creation time. go-zfs's Snapshot
+// type has no GetCreation method (that's only defined on Dataset), so this
+// reads the creation property directly the way Dataset.GetCreation does.
+func snapshotCreation(name string) (time.Time, error) {
+	out, err := exec.Command("zfs", "get", "-H", "-p", "-o", "value", "creation", name).Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("zfs get creation failed: %w", err)
+	}
+
+	ut, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ut, 0), nil
+}
+
+// rollbackDataset rolls dsName back to snapName, destroying any snapshots
+// created after it (-r). Clones of those snapshots are not destroyed (that
+// would take -R).
+func rollbackDataset(dsName, snapName string) error {
+	out, err := exec.Command("zfs", "rollback", "-r", dsName+"@"+snapName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs rollback failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// cloneSnapshot clones source ("dataset@snapshot") to target, applying
+// properties as it's created.
+func cloneSnapshot(source, target string, properties map[string]string) error {
+	args := []string{"clone"}
+	for prop, val := range properties {
+		args = append(args, "-o", prop+"="+val)
+	}
+	args = append(args, source, target)
+
+	out, err := exec.Command("zfs", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("zfs clone failed: %w: %s", err, out)
+	}
+	return nil
+}